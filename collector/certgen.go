@@ -0,0 +1,205 @@
+package collector
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// CertGenConfig holds the parameters needed to generate a self-signed (or
+// self-signing CA) certificate/key pair for the manager's TLS listener.
+// It mirrors the flags exposed by Go's crypto/tls generate_cert.go example
+// so that whids-man can offer the same flexibility on key algorithm and
+// validity window.
+type CertGenConfig struct {
+	// Hosts is the list of DNS names and/or IP addresses the certificate
+	// is valid for
+	Hosts []string
+	// IsCA marks the generated certificate as its own Certificate
+	// Authority, so it can later be used to sign client certificates
+	IsCA bool
+	// Organization is put in the certificate Subject
+	Organization string
+	// RSABits is the size of the RSA key to generate, ignored if
+	// ECDSACurve or Ed25519 is set
+	RSABits int
+	// ECDSACurve selects an ECDSA key instead of RSA. Valid values are
+	// P224, P256, P384 and P521
+	ECDSACurve string
+	// Ed25519 generates an Ed25519 key instead of RSA, ignored if
+	// ECDSACurve is set
+	Ed25519 bool
+	// NotBefore is the start of the certificate validity window, it
+	// defaults to time.Now() when zero
+	NotBefore time.Time
+	// ValidFor is the duration the certificate stays valid for, counted
+	// from NotBefore
+	ValidFor time.Duration
+}
+
+func publicKey(priv interface{}) interface{} {
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
+	default:
+		return nil
+	}
+}
+
+func pemBlockForKey(priv interface{}) (*pem.Block, error) {
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+	case *ecdsa.PrivateKey:
+		b, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal ECDSA private key: %s", err)
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}, nil
+	case ed25519.PrivateKey:
+		b, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal Ed25519 private key: %s", err)
+		}
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: b}, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %T", priv)
+	}
+}
+
+func generateKey(cfg CertGenConfig) (interface{}, error) {
+	switch cfg.ECDSACurve {
+	case "":
+		if cfg.Ed25519 {
+			_, priv, err := ed25519.GenerateKey(rand.Reader)
+			return priv, err
+		}
+		bits := cfg.RSABits
+		if bits == 0 {
+			bits = defaultRSABits
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case "P224":
+		return ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	case "P256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "P384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "P521":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unrecognized ECDSA curve: %q", cfg.ECDSACurve)
+	}
+}
+
+// GenerateCert generates a key/cert pair according to cfg and writes them to
+// certPath/keyPath in PEM format. It is exported so that both whids-man and
+// the agent can reuse it, the latter typically to bootstrap a self-signed
+// identity before enrolling against the manager.
+func GenerateCert(cfg CertGenConfig, certPath, keyPath string) error {
+	if len(cfg.Hosts) == 0 {
+		return fmt.Errorf("missing required host/IP to generate a certificate for")
+	}
+
+	priv, err := generateKey(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to generate private key: %s", err)
+	}
+
+	notBefore := cfg.NotBefore
+	if notBefore.IsZero() {
+		notBefore = time.Now()
+	}
+	validFor := cfg.ValidFor
+	if validFor == 0 {
+		validFor = time.Hour * 24 * 365
+	}
+	notAfter := notBefore.Add(validFor)
+
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return fmt.Errorf("failed to generate serial number: %s", err)
+	}
+
+	org := cfg.Organization
+	if org == "" {
+		org = defaultOrg
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{org},
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, h := range cfg.Hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	if cfg.IsCA {
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, publicKey(priv), priv)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %s", err)
+	}
+
+	certOut, err := os.OpenFile(certPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for writing: %s", certPath, err)
+	}
+	defer certOut.Close()
+	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes}); err != nil {
+		return fmt.Errorf("failed to write %s: %s", certPath, err)
+	}
+
+	keyBlock, err := pemBlockForKey(priv)
+	if err != nil {
+		return err
+	}
+
+	keyOut, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for writing: %s", keyPath, err)
+	}
+	defer keyOut.Close()
+	if err := pem.Encode(keyOut, keyBlock); err != nil {
+		return fmt.Errorf("failed to write %s: %s", keyPath, err)
+	}
+
+	return nil
+}
+
+const defaultOrg = "WHIDS Manager"
+
+// defaultRSABits is used when a caller builds a CertGenConfig without
+// specifying a key size, e.g. CertGenConfig{Hosts: [...]}, rather than
+// failing deep inside crypto/rsa with an opaque error
+const defaultRSABits = 2048
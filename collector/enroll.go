@@ -0,0 +1,258 @@
+package collector
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// EnrollPath is the HTTP path the enrollment endpoint is served on
+const EnrollPath = "/enroll"
+
+// enrollClientValidity is how long a client certificate issued through the
+// enrollment endpoint stays valid for
+const enrollClientValidity = time.Hour * 24 * 365
+
+// SignCSR checks csrDER's own signature and signs it with caCert/caKey as a
+// client certificate usable for mutual-TLS authentication against the manager. It
+// is the primitive behind the /enroll HTTP endpoint, kept standalone so it
+// can also be exercised from tests or from an offline admin command.
+func SignCSR(caCert *x509.Certificate, caKey interface{}, csrDER []byte, commonName string) ([]byte, error) {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSR: %s", err)
+	}
+
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %s", err)
+	}
+
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %s", err)
+	}
+
+	notBefore := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{defaultOrg}},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(enrollClientValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	return x509.CreateCertificate(rand.Reader, &template, caCert, csr.PublicKey, caKey)
+}
+
+// LoadCA reads back a CA cert/key pair as generated by GenerateCert with
+// CertGenConfig.IsCA set, so that it can be used to sign CSRs with SignCSR
+func LoadCA(certPath, keyPath string) (*x509.Certificate, interface{}, error) {
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CA certificate: %s", err)
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("no PEM data found in %s", certPath)
+	}
+	caCert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA certificate: %s", err)
+	}
+
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CA key: %s", err)
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("no PEM data found in %s", keyPath)
+	}
+
+	var caKey interface{}
+	switch keyBlock.Type {
+	case "RSA PRIVATE KEY":
+		caKey, err = x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	case "EC PRIVATE KEY":
+		caKey, err = x509.ParseECPrivateKey(keyBlock.Bytes)
+	case "PRIVATE KEY":
+		caKey, err = x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	default:
+		return nil, nil, fmt.Errorf("unsupported key type in %s: %s", keyPath, keyBlock.Type)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA key: %s", err)
+	}
+
+	return caCert, caKey, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a DER encoded certificate,
+// used to identify an endpoint's client certificate in the registry below
+func Fingerprint(certDER []byte) string {
+	sum := sha256.Sum256(certDER)
+	return hex.EncodeToString(sum[:])
+}
+
+// EndpointRegistry tracks the mapping between an endpoint UUID and the
+// fingerprint of the client certificate it enrolled with. It is kept
+// separate from the manager's main datastore so that it can be reused
+// (or swapped) independently of how endpoints/events are otherwise stored.
+type EndpointRegistry struct {
+	mu   sync.Mutex
+	path string
+	// Fingerprints maps an endpoint UUID to the SHA-256 fingerprint of the
+	// client certificate it was issued at enrollment time
+	Fingerprints map[string]string `json:"fingerprints"`
+}
+
+// NewEndpointRegistry creates an EndpointRegistry backed by path, loading any
+// pre-existing content
+func NewEndpointRegistry(path string) (*EndpointRegistry, error) {
+	r := &EndpointRegistry{path: path, Fingerprints: make(map[string]string)}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+		return nil, fmt.Errorf("failed to read endpoint registry: %s", err)
+	}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("failed to parse endpoint registry: %s", err)
+	}
+	return r, nil
+}
+
+// Register records that endpointUUID enrolled with the client certificate
+// fingerprinted as fingerprint, persisting the update to disk
+func (r *EndpointRegistry) Register(endpointUUID, fingerprint string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Fingerprints[endpointUUID] = fingerprint
+	return r.save()
+}
+
+// Unregister drops endpointUUID from the registry and persists the update.
+// Nothing in this package consults the registry during a TLS handshake:
+// ClientAuthTLSConfig accepts any client certificate signed by the CA, so a
+// certificate issued to an unregistered endpoint still verifies.
+func (r *EndpointRegistry) Unregister(endpointUUID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.Fingerprints, endpointUUID)
+	return r.save()
+}
+
+// save persists the registry to r.path, caller must hold r.mu
+func (r *EndpointRegistry) save() error {
+	b, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal endpoint registry: %s", err)
+	}
+	return ioutil.WriteFile(r.path, b, 0600)
+}
+
+// TokenValidator reports whether a one-time enrollment token is valid and,
+// if so, consumes it so that it cannot be replayed
+type TokenValidator func(token string) bool
+
+// Enrollment implements the manager's /enroll HTTP endpoint: it signs a CSR
+// submitted by a new endpoint against the manager's CA and records the
+// resulting fingerprint in a Registry. Its TLS listener, built with
+// NewEnrollServer, is deliberately kept separate from the manager's main
+// event-collection listener, since an enrolling endpoint has no client
+// certificate yet and so cannot be required to present one here.
+//
+// cmdEnroll/cmdRevoke in tools/manager/whids-man.go are the offline
+// counterpart, operating on the same CA/registry files for operators who
+// enroll endpoints out of band instead.
+type Enrollment struct {
+	CACert   *x509.Certificate
+	CAKey    interface{}
+	Registry *EndpointRegistry
+	Valid    TokenValidator
+}
+
+// ServeHTTP expects a POST request carrying a PEM encoded CSR as body and an
+// enrollment token in the X-Enrollment-Token header, along with the
+// enrolling endpoint's UUID in the X-Endpoint-UUID header. On success it
+// responds with the PEM encoded signed client certificate.
+func (e *Enrollment) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.Header.Get("X-Enrollment-Token")
+	endpointUUID := r.Header.Get("X-Endpoint-UUID")
+	if token == "" || endpointUUID == "" || !e.Valid(token) {
+		http.Error(w, "invalid or missing enrollment token", http.StatusUnauthorized)
+		return
+	}
+
+	csrPEM, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read CSR", http.StatusBadRequest)
+		return
+	}
+
+	block, _ := pem.Decode(csrPEM)
+	if block == nil {
+		http.Error(w, "no PEM data found in request body", http.StatusBadRequest)
+		return
+	}
+
+	certDER, err := SignCSR(e.CACert, e.CAKey, block.Bytes, endpointUUID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to sign CSR: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := e.Registry.Register(endpointUUID, Fingerprint(certDER)); err != nil {
+		http.Error(w, fmt.Sprintf("failed to record enrollment: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+}
+
+// NewEnrollServer builds the *http.Server that serves e on EnrollPath. The
+// caller is responsible for calling ListenAndServeTLS with the manager's own
+// server certificate, since clients dialing in for enrollment cannot yet
+// present one of their own.
+func NewEnrollServer(addr string, e *Enrollment) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(EnrollPath, e)
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+// ClientAuthTLSConfig builds the tls.Config the manager's main listener
+// should adopt once endpoints have enrolled against caCert: it requires and
+// verifies a client certificate signed by caCert on every connection,
+// replacing a shared API key as the authentication mechanism. Nothing in
+// this tree wires it into that listener yet, since collector.Manager's
+// listener has no source here to edit.
+func ClientAuthTLSConfig(caCert *x509.Certificate) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}
+}
@@ -0,0 +1,46 @@
+package collector
+
+// TLSConfig holds the additional TLS parameters needed to generate a
+// manager listener certificate that is valid for more than a single host:
+// a load-balancer VIP, the endpoints' DNS names and a local admin IP all at
+// once.
+//
+// This is a certgen-only stopgap: ManagerConfig itself is declared outside
+// this tree and is not extended with a TLS field here, so the manager
+// process started by "whids-man run" never sees AdditionalHosts/IPSANs/
+// KeyType/KeyBits — only the detached "whids-man certgen"/"ca-init" commands
+// do, by reading the "tls" JSON object straight out of the configuration
+// file (see loadTLSConfig). Landing TLS as a real ManagerConfig field is
+// left to whoever owns that type.
+type TLSConfig struct {
+	// AdditionalHosts are extra DNS names the certificate should be valid
+	// for, on top of ManagerConfig.Host
+	AdditionalHosts []string `json:"additionalHosts,omitempty"`
+	// IPSANs are extra IP addresses the certificate should be valid for,
+	// e.g. a load-balancer VIP or 127.0.0.1 for local admin access
+	IPSANs []string `json:"ipSans,omitempty"`
+	// KeyType selects the key algorithm to generate, one of "rsa",
+	// "ecdsa" or "ed25519". Empty defaults to "rsa"
+	KeyType string `json:"keyType,omitempty"`
+	// KeyBits is the RSA key size to generate, ignored for ecdsa/ed25519
+	KeyBits int `json:"keyBits,omitempty"`
+	// NotBefore overrides the certificate's validity start, formatted as
+	// "Jan 2 15:04:05 2006". Empty defaults to time.Now()
+	NotBefore string `json:"notBefore,omitempty"`
+	// NotAfter overrides the certificate's validity end, formatted as
+	// "Jan 2 15:04:05 2006". Empty defaults to NotBefore plus one year
+	NotAfter string `json:"notAfter,omitempty"`
+}
+
+// Hosts merges baseHost (typically ManagerConfig.Host) with the additional
+// DNS names and IP SANs configured in t, in the order GenerateCert expects
+// them
+func (t TLSConfig) Hosts(baseHost string) []string {
+	hosts := make([]string, 0, 1+len(t.AdditionalHosts)+len(t.IPSANs))
+	if baseHost != "" {
+		hosts = append(hosts, baseHost)
+	}
+	hosts = append(hosts, t.AdditionalHosts...)
+	hosts = append(hosts, t.IPSANs...)
+	return hosts
+}
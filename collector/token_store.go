@@ -0,0 +1,69 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// TokenStore persists the set of outstanding one-time enrollment tokens
+// handed out by "whids-man enroll-token" and consumed by the /enroll
+// endpoint. A token is valid exactly once: Consume removes it so it cannot
+// be replayed.
+type TokenStore struct {
+	mu     sync.Mutex
+	path   string
+	Tokens map[string]bool `json:"tokens"`
+}
+
+// NewTokenStore creates a TokenStore backed by path, loading any
+// pre-existing content
+func NewTokenStore(path string) (*TokenStore, error) {
+	t := &TokenStore{path: path, Tokens: make(map[string]bool)}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return t, nil
+		}
+		return nil, fmt.Errorf("failed to read token store: %s", err)
+	}
+	if err := json.Unmarshal(b, t); err != nil {
+		return nil, fmt.Errorf("failed to parse token store: %s", err)
+	}
+	return t, nil
+}
+
+// Issue mints a new one-time enrollment token and persists it
+func (t *TokenStore) Issue() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	token := KeyGen(DefaultKeySize)
+	t.Tokens[token] = true
+	return token, t.save()
+}
+
+// Consume reports whether token is outstanding and, if so, removes it so it
+// cannot be used again. It satisfies the TokenValidator signature expected
+// by Enrollment.
+func (t *TokenStore) Consume(token string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.Tokens[token] {
+		return false
+	}
+	delete(t.Tokens, token)
+	// best effort: a failure to persist the consumption only risks a
+	// token being replayable, not a loss of already-granted trust
+	t.save()
+	return true
+}
+
+func (t *TokenStore) save() error {
+	b, err := json.MarshalIndent(t, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal token store: %s", err)
+	}
+	return ioutil.WriteFile(t.path, b, 0600)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/collector"
+)
+
+var (
+	enroll bool
+
+	managerAddr string
+	enrollToken string
+	endpointID  string
+	caCertPath  string
+	outDir      string
+	insecure    bool
+)
+
+// enrollKeyBits is the size of the RSA key generated for an endpoint's
+// client certificate, kept modest since it is regenerated at every
+// enrollment rather than reused for years like the manager's own CA
+const enrollKeyBits = 2048
+
+// doEnroll generates a key, builds a CSR for endpointID and submits it to
+// the manager's /enroll endpoint using enrollToken as proof of a one-time
+// authorization, writing back the resulting client cert/key pair
+func doEnroll() error {
+	if managerAddr == "" || enrollToken == "" || endpointID == "" {
+		return fmt.Errorf("-manager, -token and -uuid are required")
+	}
+
+	priv, err := rsa.GenerateKey(rand.Reader, enrollKeyBits)
+	if err != nil {
+		return fmt.Errorf("failed to generate private key: %s", err)
+	}
+
+	csrTemplate := x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: endpointID},
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &csrTemplate, priv)
+	if err != nil {
+		return fmt.Errorf("failed to create CSR: %s", err)
+	}
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+
+	client := &http.Client{}
+	if caCertPath != "" {
+		caPEM, err := ioutil.ReadFile(caCertPath)
+		if err != nil {
+			return fmt.Errorf("failed to read -ca-cert: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("no certificate found in -ca-cert")
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	} else if insecure {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	} else {
+		return fmt.Errorf("either -ca-cert or -insecure must be provided to connect to the manager")
+	}
+
+	url := fmt.Sprintf("https://%s%s", managerAddr, collector.EnrollPath)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(csrPEM))
+	if err != nil {
+		return fmt.Errorf("failed to build enrollment request: %s", err)
+	}
+	req.Header.Set("X-Enrollment-Token", enrollToken)
+	req.Header.Set("X-Endpoint-UUID", endpointID)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("enrollment request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read enrollment response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("manager rejected enrollment: %s", body)
+	}
+
+	certOut := filepath.Join(outDir, "endpoint-cert.pem")
+	if err := ioutil.WriteFile(certOut, body, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %s", certOut, err)
+	}
+
+	keyOut := filepath.Join(outDir, "endpoint-key.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	if err := ioutil.WriteFile(keyOut, keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %s", keyOut, err)
+	}
+
+	log.Infof("Enrolled as %s, certificate written to %s", endpointID, certOut)
+	return nil
+}
+
+func main() {
+	flag.BoolVar(&enroll, "enroll", enroll, "Enroll this endpoint against a manager's /enroll endpoint using a one-time token")
+	flag.StringVar(&managerAddr, "manager", managerAddr, "Manager address (host:port), only used with -enroll")
+	flag.StringVar(&enrollToken, "token", enrollToken, "One-time enrollment token, only used with -enroll")
+	flag.StringVar(&endpointID, "uuid", endpointID, "This endpoint's UUID, only used with -enroll")
+	flag.StringVar(&caCertPath, "ca-cert", caCertPath, "Manager CA certificate used to verify the manager's identity, only used with -enroll")
+	flag.StringVar(&outDir, "out-dir", ".", "Directory the enrolled cert/key pair gets written to, only used with -enroll")
+	flag.BoolVar(&insecure, "insecure", insecure, "Skip manager certificate verification, only used with -enroll (testing only)")
+
+	flag.Parse()
+
+	if enroll {
+		if err := doEnroll(); err != nil {
+			log.LogErrorAndExit(err)
+		}
+		os.Exit(0)
+	}
+
+	log.LogErrorAndExit(fmt.Errorf("nothing to do, see -h"))
+}
@@ -1,22 +1,17 @@
 package main
 
 import (
-	"crypto/ecdsa"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"crypto/x509/pkix"
 	"encoding/json"
 	"encoding/pem"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math/big"
-	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/0xrawsec/golang-utils/log"
@@ -29,183 +24,456 @@ const (
 )
 
 var (
-	keygen     bool
-	certgen    bool
-	dumpConfig bool
-
 	managerConf collector.ManagerConfig
 	manager     *collector.Manager
 	osSignals   = make(chan os.Signal)
 
-	// Used for certificate generation
-	defaultOrg          = "WHIDS Manager"
-	defaultCertValidity = time.Hour * 24 * 365
+	// commands maps a subcommand name to its handler, following the same
+	// pattern as Gogs' cmd package: each subcommand owns its own flag set
+	// instead of polluting a single top-level namespace
+	commands = map[string]func(args []string){
+		"keygen":       cmdKeygen,
+		"certgen":      cmdCertgen,
+		"dump-config":  cmdDumpConfig,
+		"run":          cmdRun,
+		"enroll":       cmdEnroll,
+		"revoke":       cmdRevoke,
+		"ca-init":      cmdCAInit,
+		"enroll-token": cmdEnrollToken,
+	}
+
+	// commandsHelp gives a one-line description of each subcommand, used
+	// by the top-level usage and by "help"
+	commandsHelp = map[string]string{
+		"keygen":       "generate a random client API key",
+		"certgen":      "generate a key/cert pair for TLS connections",
+		"dump-config":  "dump a skeleton manager configuration",
+		"run":          "run the manager",
+		"enroll":       "offline: sign an endpoint's CSR and record it in the endpoint registry",
+		"revoke":       "revoke an endpoint's enrollment",
+		"ca-init":      "generate a self-signed CA cert/key pair",
+		"enroll-token": "mint a one-time token accepted by the running /enroll endpoint",
+	}
 )
 
-/////////////////////////// generate_cert.go ///////////////////////////////////
-func publicKey(priv interface{}) interface{} {
-	switch k := priv.(type) {
-	case *rsa.PrivateKey:
-		return &k.PublicKey
-	case *ecdsa.PrivateKey:
-		return &k.PublicKey
-	default:
-		return nil
+func printInfo(writer io.Writer) {
+	fmt.Fprintf(writer, "Version: %s (commit: %s)\nCopyright: %s\nLicense: %s\n\n", version, commitID, copyright, license)
+}
+
+func topLevelUsage() {
+	printInfo(os.Stderr)
+	fmt.Fprintf(os.Stderr, "Usage: %s COMMAND [OPTIONS]\n\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	for _, name := range []string{"keygen", "certgen", "dump-config", "run", "enroll", "revoke", "ca-init", "enroll-token"} {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commandsHelp[name])
 	}
+	fmt.Fprintf(os.Stderr, "\nRun \"%s help COMMAND\" for command specific options.\n", filepath.Base(os.Args[0]))
 }
 
-func pemBlockForKey(priv interface{}) *pem.Block {
-	switch k := priv.(type) {
-	case *rsa.PrivateKey:
-		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
-	case *ecdsa.PrivateKey:
-		b, err := x509.MarshalECPrivateKey(k)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to marshal ECDSA private key: %v", err)
-			os.Exit(2)
-		}
-		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}
-	default:
-		return nil
+// certValidFrom parses a start-date flag, defaulting to time.Now() when
+// empty, using the same layout as Go's generate_cert.go example
+func certValidFrom(startDate string) (time.Time, error) {
+	if startDate == "" {
+		return time.Now(), nil
 	}
+	return time.Parse("Jan 2 15:04:05 2006", startDate)
 }
 
-func generateCert(hosts []string) error {
-	if len(hosts) == 0 {
-		return fmt.Errorf("Missing required --host parameter")
+// loadManagerConfig reads and parses a manager configuration file
+func loadManagerConfig(path string) (collector.ManagerConfig, error) {
+	var conf collector.ManagerConfig
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return conf, fmt.Errorf("failed to open configuration file: %s", err)
+	}
+	defer fd.Close()
+
+	b, err := ioutil.ReadAll(fd)
+	if err != nil {
+		return conf, fmt.Errorf("failed to read configuration file: %s", err)
+	}
+
+	if err := json.Unmarshal(b, &conf); err != nil {
+		return conf, fmt.Errorf("failed to parse configuration data: %s", err)
 	}
 
-	var priv interface{}
-	var err error
+	return conf, nil
+}
 
-	// generate RSA key
-	priv, err = rsa.GenerateKey(rand.Reader, 4096)
+// loadTLSConfig reads the "tls" block out of a manager configuration file.
+// It is read independently of loadManagerConfig, rather than through a TLS
+// field on collector.ManagerConfig, because that type isn't declared in
+// this tree; only the certgen/ca-init commands below consult it, the
+// running manager created by cmdRun does not.
+func loadTLSConfig(path string) (collector.TLSConfig, error) {
+	var wrapper struct {
+		TLS collector.TLSConfig `json:"tls"`
+	}
 
+	b, err := ioutil.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to generate private key: %s", err)
+		return wrapper.TLS, fmt.Errorf("failed to read configuration file: %s", err)
+	}
+
+	if err := json.Unmarshal(b, &wrapper); err != nil {
+		return wrapper.TLS, fmt.Errorf("failed to parse configuration data: %s", err)
 	}
 
-	notBefore := time.Now()
-	notAfter := notBefore.Add(defaultCertValidity)
+	return wrapper.TLS, nil
+}
 
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+func cmdKeygen(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	fs.Parse(args)
 
+	key := collector.KeyGen(collector.DefaultKeySize)
+	fmt.Printf("New API key: %s\n", key)
+	fmt.Printf("Please manually update client and manager configuration file to make it effective\n")
+}
+
+func cmdDumpConfig(args []string) {
+	fs := flag.NewFlagSet("dump-config", flag.ExitOnError)
+	fs.Parse(args)
+
+	// Wrapped in the same shape loadTLSConfig reads back, so the skeleton
+	// documents the "tls" block that certgen/ca-init consult even though
+	// it isn't a field on collector.ManagerConfig itself
+	skeleton := struct {
+		collector.ManagerConfig
+		TLS collector.TLSConfig `json:"tls"`
+	}{}
+
+	b, err := json.MarshalIndent(skeleton, "", "    ")
 	if err != nil {
-		return fmt.Errorf("failed to generate serial number: %s", err)
+		panic(err)
 	}
+	fmt.Println(string(b))
+}
+
+// certGenFlags are shared between certgen and ca-init, only the defaults
+// and the IsCA behavior differ
+type certGenFlags struct {
+	host       *string
+	config     *string
+	outDir     *string
+	startDate  *string
+	duration   *time.Duration
+	rsaBits    *int
+	ecdsaCurve *string
+	ed25519Key *bool
+
+	// fs is kept around so applyTLSConfigDefaults can tell, via
+	// fs.Visit, which flags the user actually passed on the command
+	// line versus which are still sitting at their registered default
+	fs *flag.FlagSet
+}
 
-	template := x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			Organization: []string{defaultOrg},
-		},
-		NotBefore:             notBefore,
-		NotAfter:              notAfter,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
+func registerCertGenFlags(fs *flag.FlagSet) certGenFlags {
+	return certGenFlags{
+		host:       fs.String("host", "", "Comma-separated hostnames and IPs to generate the certificate for"),
+		config:     fs.String("config", "", "Manager configuration file to read the default host from"),
+		outDir:     fs.String("out-dir", ".", "Directory the generated cert/key pair gets written to"),
+		startDate:  fs.String("start-date", "", "Creation date formatted as Jan 1 15:04:05 2011 (defaults to now)"),
+		duration:   fs.Duration("duration", time.Hour*24*365, "Duration that the generated certificate is valid for"),
+		rsaBits:    fs.Int("rsa-bits", 4096, "Size of the RSA key to generate, ignored if -ecdsa-curve or -ed25519 is set"),
+		ecdsaCurve: fs.String("ecdsa-curve", "", "ECDSA curve to use to generate a key, valid values are P224, P256, P384, P521"),
+		ed25519Key: fs.Bool("ed25519", false, "Generate an Ed25519 key instead of RSA, ignored if -ecdsa-curve is set"),
+		fs:         fs,
 	}
+}
 
-	for _, h := range hosts {
-		if ip := net.ParseIP(h); ip != nil {
-			template.IPAddresses = append(template.IPAddresses, ip)
-		} else {
-			template.DNSNames = append(template.DNSNames, h)
+// wasSet reports whether flag name was explicitly passed on the command
+// line, as opposed to sitting at its registered default value
+func (f certGenFlags) wasSet(name string) bool {
+	set := false
+	f.fs.Visit(func(fl *flag.Flag) {
+		if fl.Name == name {
+			set = true
 		}
+	})
+	return set
+}
+
+// resolveHosts returns the hosts a cert should be generated for and the TLS
+// block configured alongside -config, if any. -host takes over the
+// config-derived host list entirely; the two are not merged. When -host is
+// set, -config is not read at all and the returned TLS block is empty.
+func resolveHosts(f certGenFlags) ([]string, collector.TLSConfig, error) {
+	if *f.host != "" {
+		return strings.Split(*f.host, ","), collector.TLSConfig{}, nil
+	}
+	if *f.config == "" {
+		return nil, collector.TLSConfig{}, fmt.Errorf("either -host or -config must be provided")
+	}
+	conf, err := loadManagerConfig(*f.config)
+	if err != nil {
+		return nil, collector.TLSConfig{}, err
+	}
+	tlsConf, err := loadTLSConfig(*f.config)
+	if err != nil {
+		return nil, collector.TLSConfig{}, err
 	}
+	return tlsConf.Hosts(conf.Host), tlsConf, nil
+}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, publicKey(priv), priv)
+// applyTLSConfigDefaults lets the config file's "tls" block provide defaults
+// for flags not passed on the command line, so a cert can be pre-generated with a
+// validity window and key type matching an operator's orchestration cycle
+// without having to repeat it on the command line
+func applyTLSConfigDefaults(f certGenFlags, tlsConf collector.TLSConfig) {
+	if !f.wasSet("start-date") && tlsConf.NotBefore != "" {
+		*f.startDate = tlsConf.NotBefore
+	}
+	if !f.wasSet("rsa-bits") && tlsConf.KeyBits != 0 {
+		*f.rsaBits = tlsConf.KeyBits
+	}
+	if !f.wasSet("ecdsa-curve") && !f.wasSet("ed25519") {
+		switch tlsConf.KeyType {
+		case "ecdsa":
+			*f.ecdsaCurve = "P256"
+		case "ed25519":
+			*f.ed25519Key = true
+		}
+	}
+}
 
+func genAndWriteCert(f certGenFlags, isCA bool, certName, keyName string) error {
+	hosts, tlsConf, err := resolveHosts(f)
 	if err != nil {
-		return fmt.Errorf("Failed to create certificate: %s", err)
+		return err
 	}
+	applyTLSConfigDefaults(f, tlsConf)
 
-	certOut, err := os.OpenFile("cert.pem", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	notBefore, err := certValidFrom(*f.startDate)
 	if err != nil {
-		return fmt.Errorf("failed to open cert.pem for writing: %s", err)
+		return fmt.Errorf("failed to parse -start-date: %s", err)
 	}
 
-	pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	validFor := *f.duration
+	if !f.wasSet("duration") && tlsConf.NotAfter != "" {
+		notAfter, err := certValidFrom(tlsConf.NotAfter)
+		if err != nil {
+			return fmt.Errorf("failed to parse tls.notAfter: %s", err)
+		}
+		validFor = notAfter.Sub(notBefore)
+	}
 
-	certOut.Close()
+	err = collector.GenerateCert(collector.CertGenConfig{
+		Hosts:      hosts,
+		IsCA:       isCA,
+		RSABits:    *f.rsaBits,
+		ECDSACurve: *f.ecdsaCurve,
+		Ed25519:    *f.ed25519Key,
+		NotBefore:  notBefore,
+		ValidFor:   validFor,
+	}, filepath.Join(*f.outDir, certName), filepath.Join(*f.outDir, keyName))
+	if err != nil {
+		return fmt.Errorf("failed to generate key/cert pair: %s", err)
+	}
 
-	log.Info("Written cert.pem")
+	return nil
+}
 
-	keyOut, err := os.OpenFile("key.pem", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+func cmdCertgen(args []string) {
+	fs := flag.NewFlagSet("certgen", flag.ExitOnError)
+	f := registerCertGenFlags(fs)
+	isCA := fs.Bool("ca", false, "Generate the certificate as its own Certificate Authority "+
+		"(sets IsCA and KeyUsageCertSign)")
+	fs.Parse(args)
 
-	if err != nil {
-		return fmt.Errorf("failed to open key.pem for writing: %s", err)
+	certName, keyName := "cert.pem", "key.pem"
+	if *isCA {
+		certName, keyName = "ca-cert.pem", "ca-key.pem"
 	}
 
-	pem.Encode(keyOut, pemBlockForKey(priv))
+	if err := genAndWriteCert(f, *isCA, certName, keyName); err != nil {
+		log.LogErrorAndExit(err)
+	}
+	log.Infof("Certificate and key generated should be used for testing purposes only.")
+}
 
-	keyOut.Close()
+func cmdCAInit(args []string) {
+	fs := flag.NewFlagSet("ca-init", flag.ExitOnError)
+	f := registerCertGenFlags(fs)
+	fs.Parse(args)
 
-	log.Info("Written key.pem")
-	return nil
+	if err := genAndWriteCert(f, true, "ca-cert.pem", "ca-key.pem"); err != nil {
+		log.LogErrorAndExit(err)
+	}
+	log.Infof("CA cert/key generated, distribute ca-cert.pem to endpoints and keep ca-key.pem secret.")
 }
 
-func printInfo(writer io.Writer) {
-	fmt.Fprintf(writer, "Version: %s (commit: %s)\nCopyright: %s\nLicense: %s\n\n", version, commitID, copyright, license)
-}
+// cmdEnroll signs a CSR offline, from direct filesystem access to the CA
+// key, for operators who enroll endpoints out of band rather than through
+// the running manager's /enroll endpoint (see cmdRun and collector.Enrollment
+// for the online, token-authenticated path)
+func cmdEnroll(args []string) {
+	fs := flag.NewFlagSet("enroll", flag.ExitOnError)
+	caCertPath := fs.String("ca-cert", "ca-cert.pem", "Manager CA certificate")
+	caKeyPath := fs.String("ca-key", "ca-key.pem", "Manager CA private key")
+	csrPath := fs.String("csr", "", "PEM encoded certificate signing request submitted by the endpoint")
+	uuid := fs.String("uuid", "", "UUID of the enrolling endpoint")
+	registryPath := fs.String("registry", "endpoints.json", "Endpoint registry file to record the enrollment in")
+	out := fs.String("out", "endpoint-cert.pem", "Path the signed certificate gets written to")
+	fs.Parse(args)
+
+	if *csrPath == "" || *uuid == "" {
+		log.LogErrorAndExit(fmt.Errorf("-csr and -uuid are required"))
+	}
 
-func main() {
+	csrPEM, err := ioutil.ReadFile(*csrPath)
+	if err != nil {
+		log.LogErrorAndExit(fmt.Errorf("failed to read CSR: %s", err))
+	}
 
-	flag.BoolVar(&keygen, "key", keygen, "Generate a random client API key. Both client and manager configuration file will needs to be updated with it.")
-	flag.BoolVar(&certgen, "certgen", certgen, "Generate a couple (key and cert) to be used for TLS connections."+
-		"The certificate gets generated for the IP address specified in the configuration file.")
-	flag.BoolVar(&dumpConfig, "dump-config", dumpConfig, "Dumps a skeleton of manager configuration")
+	caCert, caKey, err := collector.LoadCA(*caCertPath, *caKeyPath)
+	if err != nil {
+		log.LogErrorAndExit(err)
+	}
 
-	flag.Usage = func() {
-		printInfo(os.Stderr)
-		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] CONFIG_FILE\n", filepath.Base(os.Args[0]))
-		flag.PrintDefaults()
+	block, _ := pem.Decode(csrPEM)
+	if block == nil {
+		log.LogErrorAndExit(fmt.Errorf("no PEM data found in %s", *csrPath))
 	}
 
-	flag.Parse()
+	certDER, err := collector.SignCSR(caCert, caKey, block.Bytes, *uuid)
+	if err != nil {
+		log.LogErrorAndExit(fmt.Errorf("failed to sign CSR: %s", err))
+	}
 
-	config := flag.Arg(0)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	if err := ioutil.WriteFile(*out, certPEM, 0600); err != nil {
+		log.LogErrorAndExit(fmt.Errorf("failed to write %s: %s", *out, err))
+	}
 
-	if keygen {
-		key := collector.KeyGen(collector.DefaultKeySize)
-		fmt.Printf("New API key: %s\n", key)
-		fmt.Printf("Please manually update client and manager configuration file to make it effective\n")
-		os.Exit(0)
+	registry, err := collector.NewEndpointRegistry(*registryPath)
+	if err != nil {
+		log.LogErrorAndExit(err)
+	}
+	if err := registry.Register(*uuid, collector.Fingerprint(certDER)); err != nil {
+		log.LogErrorAndExit(err)
 	}
 
-	if dumpConfig {
-		b, err := json.MarshalIndent(collector.ManagerConfig{}, "", "    ")
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println(string(b))
-		os.Exit(0)
+	log.Infof("Enrolled endpoint %s, certificate written to %s", *uuid, *out)
+}
+
+// cmdRevoke is the offline counterpart to cmdEnroll: it drops an endpoint's
+// fingerprint from the registry directly, without going through the running
+// manager. The fingerprint and registry themselves are collector-level
+// primitives (EndpointRegistry.Unregister), not reimplemented here.
+func cmdRevoke(args []string) {
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	uuid := fs.String("uuid", "", "UUID of the endpoint to revoke")
+	registryPath := fs.String("registry", "endpoints.json", "Endpoint registry file to revoke the enrollment from")
+	fs.Parse(args)
+
+	if *uuid == "" {
+		log.LogErrorAndExit(fmt.Errorf("-uuid is required"))
+	}
+
+	registry, err := collector.NewEndpointRegistry(*registryPath)
+	if err != nil {
+		log.LogErrorAndExit(err)
 	}
+	if err := registry.Unregister(*uuid); err != nil {
+		log.LogErrorAndExit(err)
+	}
+
+	log.Infof("Revoked endpoint %s", *uuid)
+}
+
+func cmdEnrollToken(args []string) {
+	fs := flag.NewFlagSet("enroll-token", flag.ExitOnError)
+	tokensPath := fs.String("tokens", "enroll-tokens.json", "Token store consulted by the running /enroll endpoint")
+	fs.Parse(args)
 
-	fd, err := os.Open(config)
+	tokens, err := collector.NewTokenStore(*tokensPath)
+	if err != nil {
+		log.LogErrorAndExit(err)
+	}
+	token, err := tokens.Issue()
 	if err != nil {
-		log.LogErrorAndExit(fmt.Errorf("Failed to open configuration file: %s", err))
+		log.LogErrorAndExit(err)
 	}
 
-	b, err := ioutil.ReadAll(fd)
+	fmt.Printf("New enrollment token: %s\n", token)
+	fmt.Printf("Give it to the enrolling endpoint, it is consumed on first use\n")
+}
+
+// registerEnrollServerFlags registers the -ca-cert/-ca-key/-registry/-tokens/
+// -enroll-addr flags used by cmdRun's online enrollment server
+func registerEnrollServerFlags(fs *flag.FlagSet) (caCertPath, caKeyPath, registryPath, tokensPath, addr *string) {
+	caCertPath = fs.String("ca-cert", "", "Manager CA certificate, enables the /enroll endpoint when set")
+	caKeyPath = fs.String("ca-key", "ca-key.pem", "Manager CA private key")
+	registryPath = fs.String("registry", "endpoints.json", "Endpoint registry file")
+	tokensPath = fs.String("tokens", "enroll-tokens.json", "One-time enrollment token store")
+	addr = fs.String("enroll-addr", ":8443", "Address the /enroll endpoint listens on")
+	return
+}
+
+// startEnrollServer starts collector's /enroll endpoint in the background
+// when a CA was configured, serving it over the manager's own TLS
+// certificate. It has no client-cert requirement of its own: an enrolling
+// endpoint does not hold one yet, so it authenticates with a one-time
+// token instead (see collector.Enrollment). Once endpoints are enrolled,
+// collector.ClientAuthTLSConfig is what the manager's own event-collection
+// listener should switch to.
+func startEnrollServer(caCertPath, caKeyPath, registryPath, tokensPath, addr, certPath, keyPath string) error {
+	caCert, caKey, err := collector.LoadCA(caCertPath, caKeyPath)
 	if err != nil {
-		log.LogErrorAndExit(fmt.Errorf("Failed to read configuration file: %s", err))
+		return err
 	}
-	err = json.Unmarshal(b, &managerConf)
+
+	registry, err := collector.NewEndpointRegistry(registryPath)
 	if err != nil {
-		log.LogErrorAndExit(fmt.Errorf("Failed to parse configuration data: %s", err))
+		return err
 	}
-	// Closing configuration file
-	fd.Close()
 
-	if certgen {
-		err = generateCert([]string{managerConf.Host})
-		if err != nil {
-			log.LogErrorAndExit(fmt.Errorf("Failed to generate key/cert pair: %s", err))
+	tokens, err := collector.NewTokenStore(tokensPath)
+	if err != nil {
+		return err
+	}
+
+	srv := collector.NewEnrollServer(addr, &collector.Enrollment{
+		CACert:   caCert,
+		CAKey:    caKey,
+		Registry: registry,
+		Valid:    tokens.Consume,
+	})
+
+	go func() {
+		log.Infof("Enrollment endpoint listening on %s%s", addr, collector.EnrollPath)
+		if err := srv.ListenAndServeTLS(certPath, keyPath); err != nil && err != http.ErrServerClosed {
+			fmt.Fprintf(os.Stderr, "enrollment endpoint stopped: %s\n", err)
+		}
+	}()
+
+	return nil
+}
+
+func cmdRun(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	caCertPath, caKeyPath, registryPath, tokensPath, enrollAddr := registerEnrollServerFlags(fs)
+	certPath := fs.String("cert", "cert.pem", "Manager TLS certificate, used by the /enroll endpoint")
+	keyPath := fs.String("key", "key.pem", "Manager TLS private key, used by the /enroll endpoint")
+	fs.Parse(args)
+
+	config := fs.Arg(0)
+	if config == "" {
+		log.LogErrorAndExit(fmt.Errorf("missing required CONFIG_FILE argument"))
+	}
+
+	conf, err := loadManagerConfig(config)
+	if err != nil {
+		log.LogErrorAndExit(err)
+	}
+	managerConf = conf
+
+	if *caCertPath != "" {
+		if err := startEnrollServer(*caCertPath, *caKeyPath, *registryPath, *tokensPath, *enrollAddr, *certPath, *keyPath); err != nil {
+			log.LogErrorAndExit(fmt.Errorf("failed to start enrollment endpoint: %s", err))
 		}
-		log.Infof("Certificate and key generated should be used for testing purposes only.")
-		os.Exit(0)
 	}
 
 	manager, err = collector.NewManager(&managerConf)
@@ -224,3 +492,34 @@ func main() {
 	manager.Run()
 	manager.Wait()
 }
+
+func main() {
+	flag.Usage = topLevelUsage
+
+	if len(os.Args) < 2 {
+		topLevelUsage()
+		os.Exit(1)
+	}
+
+	cmdName, args := os.Args[1], os.Args[2:]
+
+	if cmdName == "help" {
+		if len(args) > 0 {
+			if fn, ok := commands[args[0]]; ok {
+				fn([]string{"-h"})
+				return
+			}
+		}
+		topLevelUsage()
+		return
+	}
+
+	cmd, ok := commands[cmdName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", cmdName)
+		topLevelUsage()
+		os.Exit(1)
+	}
+
+	cmd(args)
+}